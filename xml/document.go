@@ -12,12 +12,9 @@ import (
 	"errors"
 	. "gokogiri/util"
 	"gokogiri/xpath"
+	"sync"
 	"unsafe"
 	//	"runtime/debug"
-
-	// for profiling
-	"time"
-	"fmt"
 )
 
 type Document interface {
@@ -34,22 +31,23 @@ type Document interface {
 	InputEncoding() []byte
 	OutputEncoding() []byte
 	DocXPathCtx() *xpath.XPath
+	AcquireXPath() *xpath.XPath
+	ReleaseXPath(*xpath.XPath)
 	AddUnlinkedNode(unsafe.Pointer)
 	Free()
 	String() string
 	Root() *ElementNode
 	BookkeepFragment(*DocumentFragment)
 
-	// Profiling functions
-	StartProfiling(string)
-	StopProfiling()
+	SetTracer(Tracer)
+	Stats() Stats
 }
 
 //xml parse option
 const (
 	XML_PARSE_RECOVER   = 1 << 0  //relaxed parsing
-	XML_PARSE_NOERROR   = 1 << 5  //suppress error reports 
-	XML_PARSE_NOWARNING = 1 << 6  //suppress warning reports 
+	XML_PARSE_NOERROR   = 1 << 5  //suppress error reports
+	XML_PARSE_NOWARNING = 1 << 6  //suppress warning reports
 	XML_PARSE_NONET     = 1 << 11 //forbid network access
 )
 
@@ -72,15 +70,15 @@ type XmlDocument struct {
 	OutEncoding   []byte
 	UnlinkedNodes map[*C.xmlNode]bool
 	XPathCtx      *xpath.XPath
+	xpathPoolOnce sync.Once
+	xpathPool     *xpath.Pool
 	Type          int
 	InputLen      int
 
 	fragments []*DocumentFragment //save the pointers to free them when the doc is freed
 
-	// profiling data
-	ProfilingData map[string]*CountAndTime
-	NowProfiling string
-	StartTime int64
+	tracer Tracer
+	stats  Stats
 }
 
 //default encoding in byte slice
@@ -101,35 +99,36 @@ func NewDocument(p unsafe.Pointer, contentLen int, inEncoding, outEncoding []byt
 	doc.Type = xmlNode.NodeType()
 	doc.fragments = make([]*DocumentFragment, 0, initialFragments)
 	doc.Me = doc
-	doc.ProfilingData = make(map[string]*CountAndTime)
+	doc.tracer = NoopTracer{}
 	xmlNode.Document = doc
 	return
 }
 
-// for storing the number of times a function is called, and the total time
-// spent in that function
-type CountAndTime struct {
-	Count int64
-	Time int64
-}
-
-func (doc *XmlDocument) StartProfiling(fnName string) {
-	doc.NowProfiling = fnName
-
-	if doc.ProfilingData[fnName] == nil {
-		doc.ProfilingData[fnName] = &CountAndTime{ 0, 0 }
+// SetTracer installs t to receive spans for this document's ParseFragment
+// and Free calls. Passing nil restores the no-op default. Parse is traced by
+// the tracer installed with SetDefaultTracer, and XPath evaluation by the one
+// installed with xpath.SetTracer; neither is reported to t.
+func (doc *XmlDocument) SetTracer(t Tracer) {
+	if t == nil {
+		t = NoopTracer{}
 	}
-
-	doc.ProfilingData[fnName].Count++
-	doc.StartTime = time.Now().UnixNano()
+	doc.tracer = t
 }
 
-func (doc *XmlDocument) StopProfiling() {
-	stopTime := time.Now().UnixNano()
-	doc.ProfilingData[doc.NowProfiling].Time += (stopTime - doc.StartTime)
+// Stats reports counters useful for monitoring libxml2 memory pressure:
+// how many unlinked nodes are waiting to be freed in Free(), and how many
+// fragments this document is retaining. It does not count the nodes the
+// document has allocated.
+func (doc *XmlDocument) Stats() Stats {
+	doc.stats.UnlinkedNodes = len(doc.UnlinkedNodes)
+	doc.stats.Fragments = len(doc.fragments)
+	return doc.stats
 }
 
 func Parse(content, inEncoding, url []byte, options int, outEncoding []byte) (doc *XmlDocument, err error) {
+	span := currentDefaultTracer().Begin("Parse")
+	defer span.End()
+
 	inEncoding = AppendCStringTerminator(inEncoding)
 	outEncoding = AppendCStringTerminator(outEncoding)
 
@@ -198,6 +197,32 @@ func (document *XmlDocument) DocXPathCtx() (ctx *xpath.XPath) {
 	return
 }
 
+// maxIdleXPathContexts bounds how many per-goroutine XPath contexts a
+// document's pool keeps around between AcquireXPath/ReleaseXPath calls.
+const maxIdleXPathContexts = 8
+
+// AcquireXPath hands out an XPath context for exclusive use by the calling
+// goroutine, cloned from this document's root context. A libxml2
+// xmlXPathContext is not safe for concurrent use, but the document's node
+// tree is safe for concurrent read-only queries once each goroutine has its
+// own context; this lets callers run parallel XPath evaluations against one
+// parsed document without external locking. Pair every call with
+// ReleaseXPath.
+func (document *XmlDocument) AcquireXPath() *xpath.XPath {
+	document.xpathPoolOnce.Do(func() {
+		document.xpathPool = xpath.NewPool(document.DocPtr(), document.XPathCtx, maxIdleXPathContexts)
+	})
+	return document.xpathPool.Get()
+}
+
+// ReleaseXPath returns an XPath context obtained from AcquireXPath to the
+// document's pool for reuse.
+func (document *XmlDocument) ReleaseXPath(ctx *xpath.XPath) {
+	if document.xpathPool != nil {
+		document.xpathPool.Put(ctx)
+	}
+}
+
 func (document *XmlDocument) AddUnlinkedNode(nodePtr unsafe.Pointer) {
 	p := (*C.xmlNode)(nodePtr)
 	document.UnlinkedNodes[p] = true
@@ -260,6 +285,9 @@ func (document *XmlDocument) CreateCommentNode(data string) (cdata *CommentNode)
 */
 
 func (document *XmlDocument) ParseFragment(input, url []byte, options int) (fragment *DocumentFragment, err error) {
+	span := document.tracer.Begin("ParseFragment")
+	defer span.End()
+
 	root := document.Root()
 	if root == nil {
 		fragment, err = parsefragment(document, nil, input, url, options)
@@ -270,6 +298,9 @@ func (document *XmlDocument) ParseFragment(input, url []byte, options int) (frag
 }
 
 func (document *XmlDocument) Free() {
+	span := document.tracer.Begin("Free")
+	defer span.End()
+
 	//must clear the fragments first
 	//because the nodes are put in the unlinked list
 	for _, fragment := range document.fragments {
@@ -281,18 +312,9 @@ func (document *XmlDocument) Free() {
 		delete(document.UnlinkedNodes, p)
 	}
 
-	// print out profiling data
-	fmt.Println("\n******** AARON'S PROFILING DATA ********\n")
-
-	for name, data := range document.ProfilingData {
-		fmt.Printf("Calls to %s:\t%d\n", name, data.Count)
-		fmt.Printf("μsecs in %s:\t%d\n", name, data.Time/1000)
-		fmt.Println()
+	if document.xpathPool != nil {
+		document.xpathPool.Close()
 	}
-
-	fmt.Println("****************************************\n")
-
-
 	document.XPathCtx.Free()
 	C.xmlFreeDoc(document.Ptr)
 }
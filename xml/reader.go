@@ -0,0 +1,239 @@
+package xml
+
+/*
+#cgo CFLAGS: -I../../../clibs/include/libxml2
+#cgo LDFLAGS: -lxml2 -L../../../clibs/lib
+
+#include <libxml/xmlreader.h>
+#include <libxml/parser.h>
+#include <libxml/HTMLparser.h>
+
+extern int goReaderRead(void *ctx, char *buffer, int len);
+extern int goReaderClose(void *ctx);
+
+static xmlTextReaderPtr newReaderForIO(void *ctx, const char *url, const char *encoding, int options) {
+	return xmlReaderForIO((xmlInputReadCallback)goReaderRead, (xmlInputCloseCallback)goReaderClose, ctx, url, encoding, options);
+}
+
+static xmlTextReaderPtr newHTMLReaderForIO(void *ctx, const char *url, const char *encoding, int options) {
+	return htmlReaderForIO((xmlInputReadCallback)goReaderRead, (xmlInputCloseCallback)goReaderClose, ctx, url, encoding, options);
+}
+*/
+import "C"
+
+import (
+	"io"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// TokenType identifies the kind of node the Reader is currently positioned on,
+// mirroring libxml2's xmlReaderTypes enum.
+type TokenType int
+
+const (
+	NoToken TokenType = iota
+	ElementToken
+	AttributeToken
+	TextToken
+	CDataToken
+	EntityRefToken
+	EntityToken
+	ProcessingInstructionToken
+	CommentToken
+	DocumentToken
+	DocumentTypeToken
+	DocumentFragmentToken
+	NotationToken
+	WhitespaceToken
+	SignificantWhitespaceToken
+	EndElementToken
+	EndEntityToken
+	XmlDeclarationToken
+)
+
+// Reader is a forward-only, pull-based token stream over an io.Reader,
+// backed by libxml2's xmlTextReader. Unlike Parse, it never materializes
+// the full tree, so it scales to inputs far larger than available memory.
+type Reader struct {
+	Ptr     *C.xmlTextReader
+	source  io.Reader
+	handle  int
+	options int
+	doc     *XmlDocument
+	closed  bool
+}
+
+var (
+	readerRegistryMu sync.Mutex
+	readerRegistry   = make(map[int]io.Reader)
+	nextReaderHandle int
+)
+
+func registerReaderSource(r io.Reader) int {
+	readerRegistryMu.Lock()
+	defer readerRegistryMu.Unlock()
+	nextReaderHandle++
+	handle := nextReaderHandle
+	readerRegistry[handle] = r
+	return handle
+}
+
+func unregisterReaderSource(handle int) {
+	readerRegistryMu.Lock()
+	defer readerRegistryMu.Unlock()
+	delete(readerRegistry, handle)
+}
+
+//export goReaderRead
+func goReaderRead(ctx unsafe.Pointer, buffer *C.char, length C.int) C.int {
+	handle := int(uintptr(ctx))
+	readerRegistryMu.Lock()
+	r := readerRegistry[handle]
+	readerRegistryMu.Unlock()
+	if r == nil || length <= 0 {
+		return 0
+	}
+	buf := (*[1 << 30]byte)(unsafe.Pointer(buffer))[:int(length):int(length)]
+	n, err := r.Read(buf)
+	if n == 0 && err != nil {
+		if err == io.EOF {
+			return 0
+		}
+		return -1
+	}
+	return C.int(n)
+}
+
+//export goReaderClose
+func goReaderClose(ctx unsafe.Pointer) C.int {
+	unregisterReaderSource(int(uintptr(ctx)))
+	return 0
+}
+
+// NewReader creates a streaming pull-parser reading XML from input.
+func NewReader(input io.Reader, encoding, url []byte, options int) (reader *Reader, err error) {
+	return newReader(input, encoding, url, options, false)
+}
+
+// HTMLReader creates a streaming pull-parser backed by libxml2's HTML
+// reader (htmlReaderForIO) rather than its XML one, tolerating the
+// malformed markup libxml2's HTML parser is built to recover from. options
+// is interpreted as HTML_PARSE_* flags, not XML_PARSE_* ones.
+func HTMLReader(input io.Reader, encoding, url []byte, options int) (reader *Reader, err error) {
+	return newReader(input, encoding, url, options, true)
+}
+
+func newReader(input io.Reader, encoding, url []byte, options int, html bool) (reader *Reader, err error) {
+	handle := registerReaderSource(input)
+
+	var urlPtr, encodingPtr *C.char
+	if len(url) > 0 {
+		url = AppendCStringTerminator(url)
+		urlPtr = (*C.char)(unsafe.Pointer(&url[0]))
+	}
+	if len(encoding) > 0 {
+		encoding = AppendCStringTerminator(encoding)
+		encodingPtr = (*C.char)(unsafe.Pointer(&encoding[0]))
+	}
+
+	var ptr *C.xmlTextReader
+	if html {
+		ptr = C.newHTMLReaderForIO(unsafe.Pointer(uintptr(handle)), urlPtr, encodingPtr, C.int(options))
+	} else {
+		ptr = C.newReaderForIO(unsafe.Pointer(uintptr(handle)), urlPtr, encodingPtr, C.int(options))
+	}
+	if ptr == nil {
+		unregisterReaderSource(handle)
+		err = ERR_FAILED_TO_PARSE_XML
+		return
+	}
+
+	reader = &Reader{Ptr: ptr, source: input, handle: handle, options: options}
+	runtime.SetFinalizer(reader, (*Reader).Close)
+	return
+}
+
+// Read advances the reader to the next node in the stream, returning its
+// token type. It returns io.EOF once the input is exhausted.
+func (r *Reader) Read() (TokenType, error) {
+	ret := C.xmlTextReaderRead(r.Ptr)
+	switch {
+	case ret == 0:
+		return NoToken, io.EOF
+	case ret < 0:
+		return NoToken, ERR_FAILED_TO_PARSE_XML
+	}
+	return TokenType(C.xmlTextReaderNodeType(r.Ptr)), nil
+}
+
+// LocalName returns the local name of the current node.
+func (r *Reader) LocalName() string {
+	return C.GoString((*C.char)(unsafe.Pointer(C.xmlTextReaderLocalName(r.Ptr))))
+}
+
+// NamespaceURI returns the namespace URI of the current node, if any.
+func (r *Reader) NamespaceURI() string {
+	uri := C.xmlTextReaderConstNamespaceUri(r.Ptr)
+	if uri == nil {
+		return ""
+	}
+	return C.GoString((*C.char)(unsafe.Pointer(uri)))
+}
+
+// Value returns the text value of the current node.
+func (r *Reader) Value() string {
+	return C.GoString((*C.char)(unsafe.Pointer(C.xmlTextReaderConstValue(r.Ptr))))
+}
+
+// Attributes returns the current element's attributes as name/value pairs.
+func (r *Reader) Attributes() map[string]string {
+	count := int(C.xmlTextReaderAttributeCount(r.Ptr))
+	if count <= 0 {
+		return nil
+	}
+	attrs := make(map[string]string, count)
+	for i := 0; i < count; i++ {
+		C.xmlTextReaderMoveToAttributeNo(r.Ptr, C.int(i))
+		name := C.GoString((*C.char)(unsafe.Pointer(C.xmlTextReaderConstLocalName(r.Ptr))))
+		value := C.GoString((*C.char)(unsafe.Pointer(C.xmlTextReaderConstValue(r.Ptr))))
+		attrs[name] = value
+	}
+	C.xmlTextReaderMoveToElement(r.Ptr)
+	return attrs
+}
+
+// Expand promotes the current node into a full Node, rooted in a Document
+// that participates in the usual unlinked-node bookkeeping, XPath context,
+// and Free() lifecycle. Use this to run XPath queries against a sub-tree
+// without materializing the rest of the stream.
+func (r *Reader) Expand() Node {
+	nodePtr := C.xmlTextReaderExpand(r.Ptr)
+	if nodePtr == nil {
+		return nil
+	}
+	if r.doc == nil {
+		// xmlTextReaderPreserve tells the reader not to free its current
+		// document when the reader itself is freed; without it,
+		// Reader.Close (via xmlFreeTextReader) would free the very xmlDoc
+		// the Document below now owns, leaving the expanded Node dangling
+		// and double-freeing the doc when its own Free() runs.
+		C.xmlTextReaderPreserve(r.Ptr)
+		docPtr := C.xmlTextReaderCurrentDoc(r.Ptr)
+		r.doc = NewDocument(unsafe.Pointer(docPtr), 0, DefaultEncodingBytes, DefaultEncodingBytes)
+	}
+	return NewNode(unsafe.Pointer(nodePtr), r.doc)
+}
+
+// Close releases the underlying libxml2 reader and its input source.
+// It is safe to call multiple times.
+func (r *Reader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	C.xmlFreeTextReader(r.Ptr)
+	unregisterReaderSource(r.handle)
+	return nil
+}
@@ -0,0 +1,204 @@
+package xml
+
+/*
+#cgo CFLAGS: -I../../../clibs/include/libxml2
+#cgo LDFLAGS: -lxml2 -L../../../clibs/lib
+#include <stdlib.h>
+#include <libxml/c14n.h>
+#include <libxml/xmlsave.h>
+#include <libxml/xmlIO.h>
+
+extern int goWriterWrite(void *ctx, char *buffer, int len);
+extern int goWriterClose(void *ctx);
+
+// c14nInSubtree makes xmlC14NExecute render only root and its descendants.
+// Namespace nodes are passed as xmlNsPtr, so they are judged by their parent.
+static int c14nInSubtree(void *root, xmlNodePtr node, xmlNodePtr parent) {
+	xmlNodePtr cur = (node != NULL && node->type == XML_NAMESPACE_DECL) ? parent : node;
+	for (; cur != NULL; cur = cur->parent) {
+		if (cur == (xmlNodePtr)root) {
+			return 1;
+		}
+	}
+	return 0;
+}
+
+static int c14nSaveTo(xmlDocPtr doc, xmlNodePtr node, int mode, int withComments, xmlChar **inclusiveNsPrefixes, void *ctx) {
+	xmlOutputBufferPtr buf = xmlOutputBufferCreateIO((xmlOutputWriteCallback)goWriterWrite, (xmlOutputCloseCallback)goWriterClose, ctx, NULL);
+	int ret = xmlC14NExecute(doc, c14nInSubtree, node, mode, inclusiveNsPrefixes, withComments, buf);
+	xmlOutputBufferClose(buf);
+	return ret;
+}
+
+static xmlSaveCtxtPtr newSaveCtxt(void *ctx, const char *encoding, int options) {
+	return xmlSaveToIO((xmlOutputWriteCallback)goWriterWrite, (xmlOutputCloseCallback)goWriterClose, ctx, encoding, options);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"unsafe"
+)
+
+// newInclusiveNsPrefixesList builds the NULL-terminated xmlChar** that
+// xmlC14NExecute expects for its inclusive-namespace-prefixes argument, along
+// with a free func the caller must run once the call returns.
+func newInclusiveNsPrefixesList(prefixes []string) (**C.xmlChar, func()) {
+	if len(prefixes) == 0 {
+		return nil, func() {}
+	}
+
+	list := make([]*C.xmlChar, len(prefixes)+1)
+	for i, prefix := range prefixes {
+		list[i] = (*C.xmlChar)(C.CString(prefix))
+	}
+
+	return (**C.xmlChar)(unsafe.Pointer(&list[0])), func() {
+		for _, ptr := range list[:len(prefixes)] {
+			C.free(unsafe.Pointer(ptr))
+		}
+	}
+}
+
+// C14NMode selects one of the canonicalization algorithms libxml2 offers.
+type C14NMode int
+
+const (
+	C14N_1_0 C14NMode = iota
+	C14N_EXCLUSIVE_1_0
+	C14N_1_1
+)
+
+// SerializeOptions controls Node.SerializeXML, mapping onto libxml2's
+// xmlSaveToIO option flags.
+type SerializeOptions struct {
+	Encoding      string // overrides the document's output encoding when non-empty
+	Format        bool   // pretty-print (XML_SAVE_FORMAT)
+	NoDeclaration bool   // omit the leading <?xml ... ?> declaration
+	NoEmptyTags   bool   // write empty elements as start/end tag pairs instead of self-closing tags (XML_SAVE_NO_EMPTY)
+}
+
+var (
+	writerRegistryMu sync.Mutex
+	writerRegistry   = make(map[int]io.Writer)
+	nextWriterHandle int
+)
+
+func registerWriterSink(w io.Writer) int {
+	writerRegistryMu.Lock()
+	defer writerRegistryMu.Unlock()
+	nextWriterHandle++
+	handle := nextWriterHandle
+	writerRegistry[handle] = w
+	return handle
+}
+
+func unregisterWriterSink(handle int) {
+	writerRegistryMu.Lock()
+	defer writerRegistryMu.Unlock()
+	delete(writerRegistry, handle)
+}
+
+//export goWriterWrite
+func goWriterWrite(ctx unsafe.Pointer, buffer *C.char, length C.int) C.int {
+	handle := int(uintptr(ctx))
+	writerRegistryMu.Lock()
+	w := writerRegistry[handle]
+	writerRegistryMu.Unlock()
+	if w == nil || length <= 0 {
+		return 0
+	}
+	buf := (*[1 << 30]byte)(unsafe.Pointer(buffer))[:int(length):int(length)]
+	n, err := w.Write(buf)
+	if err != nil {
+		return -1
+	}
+	return C.int(n)
+}
+
+//export goWriterClose
+func goWriterClose(ctx unsafe.Pointer) C.int {
+	unregisterWriterSink(int(uintptr(ctx)))
+	return 0
+}
+
+// Canonicalize writes node through one of libxml2's c14n algorithms to w,
+// via xmlC14NExecute, so the result can be hashed or diffed deterministically
+// regardless of how the source document was formatted. inclusiveNsPrefixes
+// only applies to C14N_EXCLUSIVE_1_0 and lists prefixes that should be
+// treated as visibly used even if libxml2 wouldn't otherwise render them.
+func (node *XmlNode) Canonicalize(mode C14NMode, withComments bool, inclusiveNsPrefixes []string, w io.Writer) error {
+	handle := registerWriterSink(w)
+	defer unregisterWriterSink(handle)
+
+	comments := 0
+	if withComments {
+		comments = 1
+	}
+
+	docPtr := (*C.xmlDoc)(node.Document.DocPtr())
+	nodePtr := (*C.xmlNode)(unsafe.Pointer(node.Ptr))
+
+	prefixList, freePrefixList := newInclusiveNsPrefixesList(inclusiveNsPrefixes)
+	defer freePrefixList()
+
+	ret := C.c14nSaveTo(docPtr, nodePtr, C.int(c14nLibxmlMode(mode)), C.int(comments), prefixList, unsafe.Pointer(uintptr(handle)))
+	if ret < 0 {
+		return errors.New("xml: canonicalization failed")
+	}
+	return nil
+}
+
+func c14nLibxmlMode(mode C14NMode) int {
+	switch mode {
+	case C14N_EXCLUSIVE_1_0:
+		return 1 // XML_C14N_EXCLUSIVE_1_0
+	case C14N_1_1:
+		return 2 // XML_C14N_1_1
+	default:
+		return 0 // XML_C14N_1_0
+	}
+}
+
+// SerializeXML writes node to w using xmlSaveToIO, so no intermediate Go
+// string is materialized for large trees.
+func (node *XmlNode) SerializeXML(w io.Writer, opts SerializeOptions) error {
+	handle := registerWriterSink(w)
+	defer unregisterWriterSink(handle)
+
+	encoding := opts.Encoding
+	if encoding == "" {
+		encoding = string(node.Document.OutputEncoding())
+	}
+	encodingBytes := AppendCStringTerminator([]byte(encoding))
+	encodingPtr := (*C.char)(unsafe.Pointer(&encodingBytes[0]))
+
+	options := 0
+	if opts.Format {
+		options |= C.XML_SAVE_FORMAT
+	}
+	if opts.NoDeclaration {
+		options |= C.XML_SAVE_NO_DECL
+	}
+	if opts.NoEmptyTags {
+		options |= C.XML_SAVE_NO_EMPTY
+	}
+
+	ctxt := C.newSaveCtxt(unsafe.Pointer(uintptr(handle)), encodingPtr, C.int(options))
+	if ctxt == nil {
+		return errors.New("xml: failed to create save context")
+	}
+
+	nodePtr := (*C.xmlNode)(unsafe.Pointer(node.Ptr))
+	if C.xmlSaveTree(ctxt, nodePtr) < 0 {
+		C.xmlSaveClose(ctxt)
+		return errors.New("xml: failed to serialize node")
+	}
+	if C.xmlSaveClose(ctxt) < 0 {
+		return errors.New("xml: failed to flush serialized output")
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package xml
+
+import "sync/atomic"
+
+// Tracer receives spans for the key operations that touch libxml2:
+// Parse, ParseFragment, and Free. XPath evaluation is traced separately,
+// through the xpath package's own Tracer. It replaces the
+// always-on stdout profiling this package used to do unconditionally,
+// letting callers plug in whatever instrumentation their service already
+// uses instead.
+type Tracer interface {
+	Begin(op string) TraceSpan
+}
+
+// TraceSpan is closed with End() once the traced operation completes.
+type TraceSpan interface {
+	End()
+}
+
+// NoopTracer is the default Tracer: it discards everything. Documents use
+// it until SetTracer installs something else.
+type NoopTracer struct{}
+
+func (NoopTracer) Begin(op string) TraceSpan { return noopSpan{} }
+
+type noopSpan struct{}
+
+func (noopSpan) End() {}
+
+// tracerBox wraps a Tracer so it can be held in an atomic.Value: the
+// interface value itself isn't safe to read and write concurrently without
+// one, since defaultTracer can be reinstalled while Parse runs on another
+// goroutine.
+type tracerBox struct{ tracer Tracer }
+
+// defaultTracerBox traces package-level functions, such as Parse, that run
+// before a *XmlDocument (and therefore its own tracer) exists.
+var defaultTracerBox atomic.Value
+
+func init() {
+	defaultTracerBox.Store(tracerBox{NoopTracer{}})
+}
+
+// SetDefaultTracer installs t as the Tracer used by package-level parsing
+// functions. Passing nil restores the no-op default. Safe to call
+// concurrently with Parse.
+func SetDefaultTracer(t Tracer) {
+	if t == nil {
+		t = NoopTracer{}
+	}
+	defaultTracerBox.Store(tracerBox{t})
+}
+
+// currentDefaultTracer returns the Tracer installed by SetDefaultTracer, or
+// NoopTracer if none has been. Safe to call concurrently with SetDefaultTracer.
+func currentDefaultTracer() Tracer {
+	return defaultTracerBox.Load().(tracerBox).tracer
+}
+
+// Stats reports counters a caller can use to monitor libxml2 memory
+// pressure for a single document.
+type Stats struct {
+	UnlinkedNodes int // nodes unlinked from the tree, pending Free()
+	Fragments     int // parsed fragments this document is retaining
+}
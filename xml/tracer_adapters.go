@@ -0,0 +1,126 @@
+package xml
+
+import (
+	"context"
+	"expvar"
+	rtrace "runtime/trace"
+	"sync"
+	"time"
+)
+
+var monotonicBase = time.Now()
+
+func monotonicNanos() int64 {
+	return int64(time.Since(monotonicBase))
+}
+
+// expvar's name registry is process-global and NewInt panics on a duplicate
+// name, so the counters ExpvarTracer publishes are shared across every
+// instance through this package-level, lazily-populated registry rather than
+// each instance registering its own - otherwise two ExpvarTracers (e.g. one
+// per document, the natural way to use per-document SetTracer) that both
+// see the same op name would panic on the second instance's first Begin.
+var (
+	expvarCountersMu sync.Mutex
+	expvarCounters   = make(map[string]*expvar.Int)
+)
+
+func expvarCounterFor(op string) *expvar.Int {
+	name := "gokogiri." + op
+
+	expvarCountersMu.Lock()
+	defer expvarCountersMu.Unlock()
+	if counter, ok := expvarCounters[name]; ok {
+		return counter
+	}
+	if v, ok := expvar.Get(name).(*expvar.Int); ok {
+		expvarCounters[name] = v
+		return v
+	}
+	counter := expvar.NewInt(name)
+	expvarCounters[name] = counter
+	return counter
+}
+
+// ExpvarTracer records a call count and is suitable for exposing under
+// /debug/vars: each Begin(op) increments the counter named "gokogiri." + op.
+type ExpvarTracer struct {
+	mu       sync.Mutex
+	counters map[string]*expvar.Int
+}
+
+// NewExpvarTracer creates an ExpvarTracer. Counters are created lazily, on
+// the first Begin() for a given op name.
+func NewExpvarTracer() *ExpvarTracer {
+	return &ExpvarTracer{counters: make(map[string]*expvar.Int)}
+}
+
+func (t *ExpvarTracer) Begin(op string) TraceSpan {
+	t.mu.Lock()
+	counter, ok := t.counters[op]
+	if !ok {
+		counter = expvarCounterFor(op)
+		t.counters[op] = counter
+	}
+	t.mu.Unlock()
+	counter.Add(1)
+	return noopSpan{}
+}
+
+// RuntimeTraceTracer emits a runtime/trace task per operation, so gokogiri operations
+// show up alongside the rest of a program's execution trace when it's
+// captured with `go tool trace`.
+type RuntimeTraceTracer struct{}
+
+type runtimeTraceSpan struct {
+	task *rtrace.Task
+}
+
+func (runtimeTraceSpan) endNoop() {}
+
+func (RuntimeTraceTracer) Begin(op string) TraceSpan {
+	_, task := rtrace.NewTask(context.Background(), op)
+	return &runtimeTraceSpan{task: task}
+}
+
+func (s *runtimeTraceSpan) End() {
+	s.task.End()
+}
+
+// SpanExporter is the shape OpenTelemetry-style exporters take: a sink that
+// receives a finished span's name and duration in nanoseconds. SpanTracer
+// adapts one into a Tracer so it can be installed with SetTracer.
+type SpanExporter interface {
+	ExportSpan(name string, durationNanos int64)
+}
+
+// SpanTracer times each operation and reports it to an OpenTelemetry-style
+// SpanExporter when the span ends.
+type SpanTracer struct {
+	Exporter SpanExporter
+	now      func() int64
+}
+
+// NewSpanTracer creates a SpanTracer reporting to exporter. now defaults to
+// a monotonic nanosecond clock if nil; it is exposed mainly so adapters that
+// aren't part of this package can still integrate with their own exporter.
+func NewSpanTracer(exporter SpanExporter, now func() int64) *SpanTracer {
+	if now == nil {
+		now = monotonicNanos
+	}
+	return &SpanTracer{Exporter: exporter, now: now}
+}
+
+type exportedSpan struct {
+	tracer *SpanTracer
+	name   string
+	start  int64
+}
+
+func (t *SpanTracer) Begin(op string) TraceSpan {
+	return &exportedSpan{tracer: t, name: op, start: t.now()}
+}
+
+func (s *exportedSpan) End() {
+	s.tracer.Exporter.ExportSpan(s.name, s.tracer.now()-s.start)
+}
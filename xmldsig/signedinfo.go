@@ -0,0 +1,225 @@
+// +build xmlsec
+
+package xmldsig
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+
+	"gokogiri/xml"
+)
+
+const dsNamespace = "http://www.w3.org/2000/09/xmldsig#"
+
+// digestFor hashes canonical with the digest algorithm implied by algo.
+// All three supported signature algorithms use SHA-256 digests; only the
+// signature step differs.
+func digestFor(algo SignatureAlgorithm, canonical []byte) ([]byte, error) {
+	switch algo {
+	case RSA_SHA256, ECDSA_SHA256, HMAC_SHA256:
+		sum := sha256.Sum256(canonical)
+		return sum[:], nil
+	default:
+		return nil, ErrUnsupportedAlgo
+	}
+}
+
+func signerOpts(algo SignatureAlgorithm) crypto.SignerOpts {
+	return crypto.SHA256
+}
+
+// buildSignedInfoElement materializes <ds:SignedInfo> under node's document
+// using the existing element/namespace/child helpers on xml.Document, so the
+// element participates in the same node lifecycle as the rest of the tree.
+func buildSignedInfoElement(node xml.Node, opts SignOptions, digest []byte) xml.Node {
+	doc := node.Document()
+	signedInfo := doc.CreateElementNode("SignedInfo")
+	signedInfo.SetNamespace("ds", dsNamespace)
+
+	canonMethod := doc.CreateElementNode("CanonicalizationMethod")
+	canonMethod.SetAttr("Algorithm", canonicalizationURI(opts.Canonicalization))
+	signedInfo.AddChild(canonMethod)
+
+	sigMethod := doc.CreateElementNode("SignatureMethod")
+	sigMethod.SetAttr("Algorithm", algorithmURI(opts.Algorithm))
+	signedInfo.AddChild(sigMethod)
+
+	reference := doc.CreateElementNode("Reference")
+	reference.SetAttr("URI", opts.ReferenceURI)
+
+	digestMethod := doc.CreateElementNode("DigestMethod")
+	digestMethod.SetAttr("Algorithm", "http://www.w3.org/2001/04/xmlenc#sha256")
+	reference.AddChild(digestMethod)
+
+	digestValue := doc.CreateElementNode("DigestValue")
+	digestValue.AddChild(doc.CreateTextNode(base64.StdEncoding.EncodeToString(digest)))
+	reference.AddChild(digestValue)
+
+	signedInfo.AddChild(reference)
+	return signedInfo
+}
+
+// buildSignatureElement wraps signedInfoNode and the computed signature
+// value in the enclosing <ds:Signature> element that Sign splices into the
+// caller's tree.
+func buildSignatureElement(node xml.Node, signedInfoNode xml.Node, signatureValue []byte) xml.Node {
+	doc := node.Document()
+	signature := doc.CreateElementNode("Signature")
+	signature.SetNamespace("ds", dsNamespace)
+	signature.AddChild(signedInfoNode)
+
+	sigValue := doc.CreateElementNode("SignatureValue")
+	sigValue.AddChild(doc.CreateTextNode(base64.StdEncoding.EncodeToString(signatureValue)))
+	signature.AddChild(sigValue)
+	return signature
+}
+
+// findSignatureElement looks for a ds:Signature within node's own subtree
+// only (".//", not "//"), so Verify can't be tricked into checking a
+// signature that covers a different part of the document than node.
+func findSignatureElement(node xml.Node) xml.Node {
+	matches := node.Search(".//ds:Signature", map[string]string{"ds": dsNamespace})
+	if len(matches) == 0 {
+		return nil
+	}
+	return matches[0]
+}
+
+func parseSignedInfo(sigNode xml.Node) SignedInfo {
+	info := SignedInfo{}
+	si := extractSignedInfoNode(sigNode)
+	if si == nil {
+		return info
+	}
+	if ref := firstChildNamed(si, "Reference"); ref != nil {
+		info.ReferenceURI = ref.Attribute("URI")
+	}
+	if canonMethod := firstChildNamed(si, "CanonicalizationMethod"); canonMethod != nil {
+		info.Canonicalization = canonicalizationFromURI(canonMethod.Attribute("Algorithm"))
+	}
+	if sigMethod := firstChildNamed(si, "SignatureMethod"); sigMethod != nil {
+		info.Algorithm = algorithmFromURI(sigMethod.Attribute("Algorithm"))
+	}
+	return info
+}
+
+func extractSignedInfoNode(sigNode xml.Node) xml.Node {
+	return firstChildNamed(sigNode, "SignedInfo")
+}
+
+func extractDigestValue(sigNode xml.Node) []byte {
+	si := extractSignedInfoNode(sigNode)
+	ref := firstChildNamed(si, "Reference")
+	dv := firstChildNamed(ref, "DigestValue")
+	if dv == nil {
+		return nil
+	}
+	decoded, _ := base64.StdEncoding.DecodeString(dv.Content())
+	return decoded
+}
+
+func extractSignatureValue(sigNode xml.Node) []byte {
+	sv := firstChildNamed(sigNode, "SignatureValue")
+	if sv == nil {
+		return nil
+	}
+	decoded, _ := base64.StdEncoding.DecodeString(sv.Content())
+	return decoded
+}
+
+func firstChildNamed(node xml.Node, name string) xml.Node {
+	if node == nil {
+		return nil
+	}
+	for _, child := range node.ChildNodes() {
+		if child.Name() == name {
+			return child
+		}
+	}
+	return nil
+}
+
+func digestsEqual(a, b []byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func verifySignatureValue(algo SignatureAlgorithm, key crypto.PublicKey, signed, signature []byte) bool {
+	switch algo {
+	case HMAC_SHA256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	default:
+		return verifyAsymmetric(algo, key, signed, signature)
+	}
+}
+
+func canonicalizationURI(mode CanonicalizationMethod) string {
+	switch mode {
+	case C14NExclusive10:
+		return "http://www.w3.org/2001/10/xml-exc-c14n#"
+	case C14NExclusive10WithComments:
+		return "http://www.w3.org/2001/10/xml-exc-c14n#WithComments"
+	case C14N11:
+		return "http://www.w3.org/2006/12/xml-c14n11"
+	default:
+		return "http://www.w3.org/TR/2001/REC-xml-c14n-20010315"
+	}
+}
+
+func algorithmURI(algo SignatureAlgorithm) string {
+	switch algo {
+	case RSA_SHA256:
+		return "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	case ECDSA_SHA256:
+		return "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256"
+	case HMAC_SHA256:
+		return "http://www.w3.org/2001/04/xmldsig-more#hmac-sha256"
+	default:
+		return ""
+	}
+}
+
+// canonicalizationFromURI is the inverse of canonicalizationURI, used to
+// recover the CanonicalizationMethod a SignedInfo element declared.
+func canonicalizationFromURI(uri string) CanonicalizationMethod {
+	switch uri {
+	case "http://www.w3.org/2001/10/xml-exc-c14n#":
+		return C14NExclusive10
+	case "http://www.w3.org/2001/10/xml-exc-c14n#WithComments":
+		return C14NExclusive10WithComments
+	case "http://www.w3.org/2006/12/xml-c14n11":
+		return C14N11
+	default:
+		return C14N10
+	}
+}
+
+// algorithmFromURI is the inverse of algorithmURI, used to recover the
+// SignatureAlgorithm a SignedInfo element declared.
+func algorithmFromURI(uri string) SignatureAlgorithm {
+	switch uri {
+	case "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256":
+		return RSA_SHA256
+	case "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256":
+		return ECDSA_SHA256
+	case "http://www.w3.org/2001/04/xmldsig-more#hmac-sha256":
+		return HMAC_SHA256
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,34 @@
+// +build xmlsec
+
+package xmldsig
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+)
+
+// verifyAsymmetric checks signature against signed using the RSA or ECDSA
+// public key supplied by the caller; HMAC is handled separately by
+// verifySignatureValue since it has no PublicKey representation.
+func verifyAsymmetric(algo SignatureAlgorithm, key crypto.PublicKey, signed, signature []byte) bool {
+	digest := sha256.Sum256(signed)
+
+	switch algo {
+	case RSA_SHA256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+	case ECDSA_SHA256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return false
+		}
+		return ecdsa.VerifyASN1(pub, digest[:], signature)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,240 @@
+// Package xmldsig signs and verifies XML Digital Signatures (XMLDSig) over
+// gokogiri xml.Document and xml.Node trees. It canonicalizes through
+// libxml2 (xml.Node.Canonicalize) and signs and verifies with Go's crypto
+// packages; libxmlsec1 is not used. It is only compiled in when the xmlsec
+// build tag is set.
+// +build xmlsec
+
+package xmldsig
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"strings"
+
+	"gokogiri/xml"
+	"gokogiri/xpath"
+)
+
+// CanonicalizationMethod identifies one of the c14n algorithms libxml2
+// implements, used both for the SignedInfo transform chain and for
+// canonicalizing the referenced content before digesting.
+type CanonicalizationMethod int
+
+const (
+	C14N10 CanonicalizationMethod = iota
+	C14NExclusive10
+	C14NExclusive10WithComments
+	C14N11
+)
+
+// SignatureAlgorithm identifies the signing algorithm written into
+// <ds:SignatureMethod> and used to produce the signature value.
+type SignatureAlgorithm string
+
+const (
+	RSA_SHA256   SignatureAlgorithm = "rsa-sha256"
+	ECDSA_SHA256 SignatureAlgorithm = "ecdsa-sha256"
+	HMAC_SHA256  SignatureAlgorithm = "hmac-sha256"
+)
+
+// SignOptions controls how Sign produces a <ds:Signature> element.
+type SignOptions struct {
+	Algorithm        SignatureAlgorithm
+	Canonicalization CanonicalizationMethod
+	ReferenceURI     string // empty means enveloped, signing the node passed to Sign
+	ReferenceXPath    string // alternative to ReferenceURI: select the subtree to sign
+	Detached         bool   // sign ReferenceURI/ReferenceXPath without embedding the signature as a child; Sign still returns the built node, unlinked
+}
+
+// SignedInfo is the result of a successful Verify: the canonicalization and
+// signature algorithms that were used, and the reference URI that was
+// checked, so callers can confirm the signature covered what they expected.
+type SignedInfo struct {
+	Algorithm        SignatureAlgorithm
+	Canonicalization CanonicalizationMethod
+	ReferenceURI     string
+	DigestMatched    bool
+}
+
+var (
+	ErrNoReferenceMatch  = errors.New("xmldsig: reference URI or XPath did not select any node")
+	ErrDigestMismatch    = errors.New("xmldsig: digest value does not match referenced content")
+	ErrSignatureInvalid  = errors.New("xmldsig: signature value did not verify against the supplied key(s)")
+	ErrUnsupportedAlgo   = errors.New("xmldsig: unsupported signature algorithm")
+)
+
+// Sign canonicalizes the subtree selected by opts (the whole node if
+// ReferenceURI/ReferenceXPath are empty), digests it, builds a
+// <ds:SignedInfo> element, canonicalizes and signs that, and returns the
+// resulting <ds:Signature> node. It is spliced into node's tree as a child
+// unless opts.Detached is set, in which case it is left unlinked (bookkept
+// on node's document so it is still freed when the document is) for the
+// caller to attach wherever it belongs.
+func Sign(node xml.Node, key crypto.Signer, opts SignOptions) (xml.Node, error) {
+	target, err := resolveReference(node, opts.ReferenceURI, opts.ReferenceXPath)
+	if err != nil {
+		return nil, err
+	}
+
+	canonical, err := canonicalize(target, opts.Canonicalization)
+	if err != nil {
+		return nil, err
+	}
+
+	digest, err := digestFor(opts.Algorithm, canonical)
+	if err != nil {
+		return nil, err
+	}
+
+	signedInfoNode := buildSignedInfoElement(node, opts, digest)
+
+	signedInfoCanonical, err := canonicalize(signedInfoNode, opts.Canonicalization)
+	if err != nil {
+		return nil, err
+	}
+
+	signedInfoDigest := sha256.Sum256(signedInfoCanonical)
+	signatureValue, err := key.Sign(rand.Reader, signedInfoDigest[:], signerOpts(opts.Algorithm))
+	if err != nil {
+		return nil, err
+	}
+
+	signatureNode := buildSignatureElement(node, signedInfoNode, signatureValue)
+	if opts.Detached {
+		node.Document().AddUnlinkedNode(signatureNode.NodePtr())
+	} else {
+		node.AddChild(signatureNode)
+	}
+	return signatureNode, nil
+}
+
+// Verify recomputes the digest and signature over the first <ds:Signature>
+// element in node's subtree against each of keys in turn, returning the
+// SignedInfo describing what was checked on the first key that validates.
+func Verify(node xml.Node, keys []crypto.PublicKey) (SignedInfo, error) {
+	sigNode := findSignatureElement(node)
+	if sigNode == nil {
+		return SignedInfo{}, errors.New("xmldsig: no ds:Signature element found")
+	}
+
+	info := parseSignedInfo(sigNode)
+
+	target, err := resolveReference(node, info.ReferenceURI, "")
+	if err != nil {
+		return info, err
+	}
+
+	canonical, err := canonicalize(target, info.Canonicalization)
+	if err != nil {
+		return info, err
+	}
+
+	digest, err := digestFor(info.Algorithm, canonical)
+	if err != nil {
+		return info, err
+	}
+
+	if !digestsEqual(digest, extractDigestValue(sigNode)) {
+		return info, ErrDigestMismatch
+	}
+	info.DigestMatched = true
+
+	signedInfoCanonical, err := canonicalize(extractSignedInfoNode(sigNode), info.Canonicalization)
+	if err != nil {
+		return info, err
+	}
+
+	for _, key := range keys {
+		if verifySignatureValue(info.Algorithm, key, signedInfoCanonical, extractSignatureValue(sigNode)) {
+			return info, nil
+		}
+	}
+	return info, ErrSignatureInvalid
+}
+
+// resolveReference runs xpathExpr (or, if empty, treats uri as a same-document
+// fragment reference "#id") against node's document XPath context to select
+// the subtree that a reference URI or explicit XPath names. An explicit
+// xpathExpr is evaluated as given, with node as the context node. For a uri,
+// the search is scoped to node's own subtree (".//", not "//") and the id value is passed
+// as an escaped XPath string literal, not concatenated into the expression,
+// so a hostile ds:Reference URI can't inject XPath syntax or resolve to a
+// node outside node's subtree (an XML Signature wrapping attack).
+func resolveReference(node xml.Node, uri, xpathExpr string) (xml.Node, error) {
+	if xpathExpr == "" && uri == "" {
+		return node, nil
+	}
+
+	ctx := node.Document().DocXPathCtx()
+	expr := xpathExpr
+	if expr == "" {
+		id := xpathLiteral(trimFragment(uri))
+		expr = ".//*[@Id=" + id + " or @ID=" + id + "]"
+	}
+
+	compiled, err := xpath.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	matches := ctx.Evaluate(node.NodePtr(), compiled)
+	if len(matches) == 0 {
+		return nil, ErrNoReferenceMatch
+	}
+	return xml.NewNode(matches[0], node.Document()), nil
+}
+
+func trimFragment(uri string) string {
+	if len(uri) > 0 && uri[0] == '#' {
+		return uri[1:]
+	}
+	return uri
+}
+
+// xpathLiteral renders s as an XPath 1.0 string literal, safe to splice
+// directly into an expression regardless of which quote characters s
+// contains. XPath 1.0 has no escape sequence within string literals, so a
+// value containing both ' and " is built with concat() over single-quoted
+// pieces instead.
+func xpathLiteral(s string) string {
+	if !strings.Contains(s, `'`) {
+		return `'` + s + `'`
+	}
+	if !strings.Contains(s, `"`) {
+		return `"` + s + `"`
+	}
+	parts := strings.Split(s, `'`)
+	pieces := make([]string, 0, len(parts)*2-1)
+	for i, part := range parts {
+		if i > 0 {
+			pieces = append(pieces, `"'"`)
+		}
+		pieces = append(pieces, `'`+part+`'`)
+	}
+	return "concat(" + strings.Join(pieces, ", ") + ")"
+}
+
+// canonicalize defers to Node.Canonicalize, so xmldsig and ordinary callers
+// share the same c14n code path.
+func canonicalize(node xml.Node, mode CanonicalizationMethod) ([]byte, error) {
+	var buf bytes.Buffer
+	withComments := mode == C14NExclusive10WithComments
+	if err := node.Canonicalize(c14nModeOf(mode), withComments, nil, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func c14nModeOf(mode CanonicalizationMethod) xml.C14NMode {
+	switch mode {
+	case C14NExclusive10, C14NExclusive10WithComments:
+		return xml.C14N_EXCLUSIVE_1_0
+	case C14N11:
+		return xml.C14N_1_1
+	default:
+		return xml.C14N_1_0
+	}
+}
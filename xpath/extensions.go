@@ -0,0 +1,139 @@
+package xpath
+
+/*
+#cgo CFLAGS: -I../../../clibs/include/libxml2
+#cgo LDFLAGS: -lxml2 -L../../../clibs/lib
+#include <libxml/xpath.h>
+#include <libxml/xpathInternals.h>
+
+void goXPathFuncTrampoline(xmlXPathParserContextPtr ctxt, int nargs);
+
+static void registerGoFunction(xmlXPathContextPtr ctx, const xmlChar *name, const xmlChar *ns) {
+	xmlXPathRegisterFuncNS(ctx, name, ns, goXPathFuncTrampoline);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	. "gokogiri/util"
+)
+
+// XPathValueType tags the union carried by XPathValue.
+type XPathValueType int
+
+const (
+	NodeSetValue XPathValueType = iota
+	StringValue
+	NumberValue
+	BooleanValue
+)
+
+// XPathValue is a tagged union mirroring the value types libxml2's XPath
+// engine pushes and pops on its value stack, so Go extension functions can
+// both receive arguments and return a result without depending on cgo types.
+type XPathValue struct {
+	Type    XPathValueType
+	NodeSet []unsafe.Pointer
+	Str     string
+	Num     float64
+	Bool    bool
+}
+
+// XPathContext exposes the parts of a running XPath evaluation that an
+// extension function is allowed to inspect. ContextNode and CurrentNode
+// both hold libxml2's context node at the time of the call (the node being
+// tested); the node an Evaluate call started from is not exposed.
+type XPathContext struct {
+	ContextNode unsafe.Pointer
+	CurrentNode unsafe.Pointer
+}
+
+// XPathFunc is a Go implementation of an XPath extension function,
+// registered with RegisterFunction under a namespace and name, e.g.
+// re:match() or str:lower-case().
+type XPathFunc func(ctx *XPathContext, args []XPathValue) (XPathValue, error)
+
+// RegisterFunction installs fn as the implementation of the XPath function
+// {ns}name, backed by xmlXPathRegisterFuncNS. fn is kept alive in a registry
+// owned by xpath for the lifetime of the XPath (freed in Free()); libxml2
+// does not pass user data to XPath callbacks, so the trampoline dispatches
+// purely by looking up the currently-evaluating function's registered key.
+func (xpath *XPath) RegisterFunction(ns, name string, fn XPathFunc) error {
+	if xpath.ContextPtr == nil {
+		return errors.New("xpath: context is not initialized")
+	}
+	if xpath.functions == nil {
+		xpath.functions = make(map[string]XPathFunc)
+	}
+
+	key := funcKey(ns, name)
+	xpath.functions[key] = fn
+	xpath.registeredFunctions = append(xpath.registeredFunctions, funcRegistration{ns: ns, name: name, fn: fn})
+	registerActiveXPath(xpath)
+
+	nameBytes := AppendCStringTerminator([]byte(name))
+	namePtr := (*C.xmlChar)(unsafe.Pointer(&nameBytes[0]))
+
+	var nsPtr *C.xmlChar
+	if len(ns) > 0 {
+		nsBytes := AppendCStringTerminator([]byte(ns))
+		nsPtr = (*C.xmlChar)(unsafe.Pointer(&nsBytes[0]))
+	}
+
+	C.registerGoFunction(xpath.ContextPtr, namePtr, nsPtr)
+	return nil
+}
+
+// RegisterVariable binds value as the XPath variable {ns}name, backed by
+// xmlXPathRegisterVariableNS. value must be a string, float64, bool, or
+// []unsafe.Pointer node-set.
+func (xpath *XPath) RegisterVariable(ns, name string, value interface{}) error {
+	if xpath.ContextPtr == nil {
+		return errors.New("xpath: context is not initialized")
+	}
+
+	xpathValue, err := toXPathValue(value)
+	if err != nil {
+		return err
+	}
+	obj := newXmlXPathObject(xpathValue)
+
+	nameBytes := AppendCStringTerminator([]byte(name))
+	namePtr := (*C.xmlChar)(unsafe.Pointer(&nameBytes[0]))
+
+	var nsPtr *C.xmlChar
+	if len(ns) > 0 {
+		nsBytes := AppendCStringTerminator([]byte(ns))
+		nsPtr = (*C.xmlChar)(unsafe.Pointer(&nsBytes[0]))
+	}
+
+	result := C.xmlXPathRegisterVariableNS(xpath.ContextPtr, namePtr, nsPtr, obj)
+	if result != 0 {
+		return fmt.Errorf("xpath: failed to register variable %s:%s", ns, name)
+	}
+	xpath.variables = append(xpath.variables, varRegistration{ns: ns, name: name, value: value})
+	return nil
+}
+
+func funcKey(ns, name string) string {
+	return ns + ":" + name
+}
+
+func toXPathValue(value interface{}) (XPathValue, error) {
+	switch v := value.(type) {
+	case string:
+		return XPathValue{Type: StringValue, Str: v}, nil
+	case float64:
+		return XPathValue{Type: NumberValue, Num: v}, nil
+	case bool:
+		return XPathValue{Type: BooleanValue, Bool: v}, nil
+	case []unsafe.Pointer:
+		return XPathValue{Type: NodeSetValue, NodeSet: v}, nil
+	default:
+		return XPathValue{}, fmt.Errorf("xpath: unsupported variable value type %T", value)
+	}
+}
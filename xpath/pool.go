@@ -0,0 +1,86 @@
+package xpath
+
+import "unsafe"
+
+// Pool hands out per-goroutine XPath contexts cloned from a single source
+// context: every namespace, Go extension function and variable registered
+// on source at the time of NewPool (and since, if source is reused) is
+// replayed onto each context Get mints, so callers that configure a
+// document's root context before calling AcquireXPath don't lose those
+// registrations in pooled contexts. A libxml2 xmlXPathContext is not safe
+// for concurrent use (Evaluate mutates ContextPtr.node and ResultPtr in
+// place), but a parsed document's node tree is safe for concurrent
+// read-only queries as long as each goroutine evaluates against its own
+// context.
+type Pool struct {
+	docPtr unsafe.Pointer
+	source *XPath
+	idle   chan *XPath
+}
+
+// NewPool creates a Pool of XPath contexts backed by docPtr, the libxml2
+// document pointer to evaluate against. source, if non-nil, is the context
+// whose namespace/function/variable registrations new pooled contexts
+// inherit. maxIdle bounds how many contexts the pool keeps around between
+// uses; Get beyond that count allocates a fresh context that Put then
+// discards instead of returning to the pool.
+func NewPool(docPtr unsafe.Pointer, source *XPath, maxIdle int) *Pool {
+	if maxIdle <= 0 {
+		maxIdle = 1
+	}
+	return &Pool{docPtr: docPtr, source: source, idle: make(chan *XPath, maxIdle)}
+}
+
+// Get returns an XPath context for exclusive use by the calling goroutine,
+// reusing an idle one from the pool when available.
+func (p *Pool) Get() *XPath {
+	select {
+	case xpath := <-p.idle:
+		return xpath
+	default:
+		return p.newFromSource()
+	}
+}
+
+// newFromSource mints a fresh context against docPtr and replays every
+// registration recorded on source, so it behaves like source for namespace
+// prefixes, extension functions and variables already set up there.
+func (p *Pool) newFromSource() *XPath {
+	xpath := NewXPath(p.docPtr)
+	if p.source == nil || xpath == nil {
+		return xpath
+	}
+	for _, ns := range p.source.namespaces {
+		xpath.RegisterNamespace(ns.prefix, ns.href)
+	}
+	for _, fn := range p.source.registeredFunctions {
+		xpath.RegisterFunction(fn.ns, fn.name, fn.fn)
+	}
+	for _, v := range p.source.variables {
+		xpath.RegisterVariable(v.ns, v.name, v.value)
+	}
+	return xpath
+}
+
+// Put returns xpath to the pool for reuse, or frees it if the pool is
+// already at capacity.
+func (p *Pool) Put(xpath *XPath) {
+	select {
+	case p.idle <- xpath:
+	default:
+		xpath.Free()
+	}
+}
+
+// Close frees every idle context currently held by the pool. It does not
+// affect contexts that are checked out via Get.
+func (p *Pool) Close() {
+	for {
+		select {
+		case xpath := <-p.idle:
+			xpath.Free()
+		default:
+			return
+		}
+	}
+}
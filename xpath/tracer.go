@@ -0,0 +1,55 @@
+package xpath
+
+import "sync/atomic"
+
+// Tracer receives a span for each compiled XPath evaluation. It mirrors
+// xml.Tracer but is defined independently here so this package doesn't
+// depend on gokogiri/xml. Begin returns a different TraceSpan type in each
+// package, so one value cannot implement both; callers that want one sink
+// observing both install an adapter for each package (SetTracer here,
+// xml.SetDefaultTracer and Document.SetTracer there).
+type Tracer interface {
+	Begin(op string) TraceSpan
+}
+
+// TraceSpan is closed with End() once the traced operation completes.
+type TraceSpan interface {
+	End()
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Begin(op string) TraceSpan { return noopSpan{} }
+
+type noopSpan struct{}
+
+func (noopSpan) End() {}
+
+// tracerBox wraps a Tracer so it can be held in an atomic.Value: the
+// interface value itself isn't safe to read and write concurrently without
+// one, since the tracer can be reinstalled while Evaluate runs on another
+// goroutine.
+type tracerBox struct{ tracer Tracer }
+
+// tracerValue is the package-wide Tracer used by Evaluate. It defaults to a
+// no-op implementation.
+var tracerValue atomic.Value
+
+func init() {
+	tracerValue.Store(tracerBox{noopTracer{}})
+}
+
+// SetTracer installs t as the Tracer used by Evaluate. Passing nil restores
+// the no-op default. Safe to call concurrently with Evaluate.
+func SetTracer(t Tracer) {
+	if t == nil {
+		t = noopTracer{}
+	}
+	tracerValue.Store(tracerBox{t})
+}
+
+// currentTracer returns the Tracer installed by SetTracer, or noopTracer if
+// none has been. Safe to call concurrently with SetTracer.
+func currentTracer() Tracer {
+	return tracerValue.Load().(tracerBox).tracer
+}
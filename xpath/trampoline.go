@@ -0,0 +1,148 @@
+package xpath
+
+/*
+#cgo CFLAGS: -I../../../clibs/include/libxml2
+#cgo LDFLAGS: -lxml2 -L../../../clibs/lib
+#include <libxml/xpath.h>
+#include <libxml/xpathInternals.h>
+
+xmlXPathObjectPtr popValue(xmlXPathParserContextPtr ctxt) {
+	return valuePop(ctxt);
+}
+
+xmlNode* fetchNodeFromSet(xmlNodeSet *nodeset, int index) {
+	return nodeset->nodeTab[index];
+}
+
+void pushValue(xmlXPathParserContextPtr ctxt, xmlXPathObjectPtr obj) {
+	valuePush(ctxt, obj);
+}
+*/
+import "C"
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// activeXPaths lets the cgo trampoline recover the *XPath (and thus its
+// registered Go functions) that owns a given libxml2 xmlXPathContext, since
+// xmlXPathRegisterFuncNS has no user-data slot to carry it for us.
+var (
+	activeXPathsMu sync.Mutex
+	activeXPaths   = make(map[*C.xmlXPathContext]*XPath)
+)
+
+func registerActiveXPath(xpath *XPath) {
+	activeXPathsMu.Lock()
+	activeXPaths[xpath.ContextPtr] = xpath
+	activeXPathsMu.Unlock()
+}
+
+func lookupActiveXPath(ctx *C.xmlXPathContext) *XPath {
+	activeXPathsMu.Lock()
+	defer activeXPathsMu.Unlock()
+	return activeXPaths[ctx]
+}
+
+func unregisterActiveXPath(ctx *C.xmlXPathContext) {
+	activeXPathsMu.Lock()
+	delete(activeXPaths, ctx)
+	activeXPathsMu.Unlock()
+}
+
+//export goXPathFuncTrampoline
+func goXPathFuncTrampoline(ctxt *C.xmlXPathParserContext, nargs C.int) {
+	pctx := ctxt.context
+	xpath := lookupActiveXPath(pctx)
+	if xpath == nil {
+		C.xmlXPathSetError(ctxt, C.XPATH_UNKNOWN_FUNC_ERROR)
+		return
+	}
+
+	name := C.GoString((*C.char)(unsafe.Pointer(pctx.function)))
+	ns := ""
+	if pctx.functionURI != nil {
+		ns = C.GoString((*C.char)(unsafe.Pointer(pctx.functionURI)))
+	}
+
+	fn, ok := xpath.functions[funcKey(ns, name)]
+	if !ok {
+		C.xmlXPathSetError(ctxt, C.XPATH_UNKNOWN_FUNC_ERROR)
+		return
+	}
+
+	args := make([]XPathValue, int(nargs))
+	for i := int(nargs) - 1; i >= 0; i-- {
+		args[i] = fromXmlXPathObject(C.popValue(ctxt))
+	}
+
+	xctx := &XPathContext{
+		ContextNode: unsafe.Pointer(pctx.node),
+		CurrentNode: unsafe.Pointer(pctx.node),
+	}
+
+	result, err := fn(xctx, args)
+	if err != nil {
+		C.xmlXPathSetError(ctxt, C.XPATH_EXPR_ERROR)
+		return
+	}
+
+	C.pushValue(ctxt, newXmlXPathObject(result))
+}
+
+// newXmlXPathObject converts an XPathValue into the libxml2 object type
+// expected on the XPath value stack (and by xmlXPathRegisterVariableNS).
+func newXmlXPathObject(value XPathValue) *C.xmlXPathObject {
+	switch value.Type {
+	case StringValue:
+		strBytes := append([]byte(value.Str), 0)
+		return C.xmlXPathNewCString((*C.char)(unsafe.Pointer(&strBytes[0])))
+	case NumberValue:
+		return C.xmlXPathNewFloat(C.double(value.Num))
+	case BooleanValue:
+		b := 0
+		if value.Bool {
+			b = 1
+		}
+		return C.xmlXPathNewBoolean(C.int(b))
+	case NodeSetValue:
+		nodeSet := C.xmlXPathNodeSetCreate(nil)
+		for _, node := range value.NodeSet {
+			C.xmlXPathNodeSetAdd(nodeSet, (*C.xmlNode)(node))
+		}
+		return C.xmlXPathWrapNodeSet(nodeSet)
+	default:
+		return C.xmlXPathNewBoolean(0)
+	}
+}
+
+// fromXmlXPathObject converts a value popped off the XPath value stack into
+// an XPathValue, freeing the underlying libxml2 object.
+func fromXmlXPathObject(obj *C.xmlXPathObject) XPathValue {
+	defer C.xmlXPathFreeObject(obj)
+	if obj == nil {
+		return XPathValue{Type: BooleanValue, Bool: false}
+	}
+
+	switch obj._type {
+	case C.XPATH_STRING:
+		return XPathValue{Type: StringValue, Str: C.GoString((*C.char)(unsafe.Pointer(obj.stringval)))}
+	case C.XPATH_NUMBER:
+		return XPathValue{Type: NumberValue, Num: float64(obj.floatval)}
+	case C.XPATH_BOOLEAN:
+		return XPathValue{Type: BooleanValue, Bool: obj.boolval != 0}
+	case C.XPATH_NODESET:
+		nodeset := obj.nodesetval
+		if nodeset == nil || nodeset.nodeNr == 0 {
+			return XPathValue{Type: NodeSetValue}
+		}
+		nodes := make([]unsafe.Pointer, int(nodeset.nodeNr))
+		for i := 0; i < int(nodeset.nodeNr); i++ {
+			nodes[i] = unsafe.Pointer(C.fetchNodeFromSet(nodeset, C.int(i)))
+		}
+		return XPathValue{Type: NodeSetValue, NodeSet: nodes}
+	default:
+		return XPathValue{Type: BooleanValue, Bool: C.xmlXPathCastToBoolean(obj) != 0}
+	}
+}
@@ -1,9 +1,9 @@
 package xpath
 
-/* 
+/*
 #cgo CFLAGS: -I../../../clibs/include/libxml2
 #cgo LDFLAGS: -lxml2 -L../../../clibs/lib
-#include <libxml/xpath.h> 
+#include <libxml/xpath.h>
 #include <libxml/xpathInternals.h>
 #include <libxml/parser.h>
 
@@ -19,6 +19,34 @@ import "runtime"
 type XPath struct {
 	ContextPtr *C.xmlXPathContext
 	ResultPtr  *C.xmlXPathObject
+
+	// functions holds the Go callbacks registered via RegisterFunction,
+	// keyed the same way libxml2 keys them internally ("ns:name"), so the
+	// cgo trampoline in extensions.go can dispatch a libxml2 callback back
+	// to the Go function that registered it.
+	functions map[string]XPathFunc
+
+	// namespaces, registeredFunctions and variables record every
+	// RegisterNamespace/RegisterFunction/RegisterVariable call made against
+	// this context, in order, so Pool can replay the same registrations onto
+	// a freshly minted pooled context cloned from this one.
+	namespaces          []nsRegistration
+	registeredFunctions []funcRegistration
+	variables           []varRegistration
+}
+
+type nsRegistration struct {
+	prefix, href string
+}
+
+type funcRegistration struct {
+	ns, name string
+	fn       XPathFunc
+}
+
+type varRegistration struct {
+	ns, name string
+	value    interface{}
 }
 
 func NewXPath(docPtr unsafe.Pointer) (xpath *XPath) {
@@ -44,6 +72,9 @@ func (xpath *XPath) RegisterNamespace(prefix, href string) bool {
 	}
 
 	result := C.xmlXPathRegisterNs(xpath.ContextPtr, (*C.xmlChar)(prefixPtr), (*C.xmlChar)(hrefPtr))
+	if result == 0 {
+		xpath.namespaces = append(xpath.namespaces, nsRegistration{prefix: prefix, href: href})
+	}
 	return result == 0
 }
 
@@ -51,6 +82,9 @@ func (xpath *XPath) Evaluate(nodePtr unsafe.Pointer, xpathExpr *Expression) (nod
 	if nodePtr == nil {
 		return
 	}
+	span := currentTracer().Begin("xmlXPathCompiledEval")
+	defer span.End()
+
 	xpath.ContextPtr.node = (*C.xmlNode)(nodePtr)
 	if xpath.ResultPtr != nil {
 		C.xmlXPathFreeObject(xpath.ResultPtr)
@@ -69,6 +103,7 @@ func (xpath *XPath) Evaluate(nodePtr unsafe.Pointer, xpathExpr *Expression) (nod
 
 func (xpath *XPath) Free() {
 	if xpath.ContextPtr != nil {
+		unregisterActiveXPath(xpath.ContextPtr)
 		C.xmlXPathFreeContext(xpath.ContextPtr)
 		xpath.ContextPtr = nil
 	}
@@ -76,4 +111,8 @@ func (xpath *XPath) Free() {
 		C.xmlXPathFreeObject(xpath.ResultPtr)
 		xpath.ResultPtr = nil
 	}
+	xpath.functions = nil
+	xpath.namespaces = nil
+	xpath.registeredFunctions = nil
+	xpath.variables = nil
 }